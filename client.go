@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer.
+	pongWait = 60 * time.Second
+
+	// Send pings to peer with this period. Must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum message size allowed from peer.
+	maxMessageSize = 512
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true,
+}
+
+// Client is a middleman between a websocket connection and the hub. It owns
+// the connection and is the only goroutine allowed to write to it; readPump
+// and writePump run in their own goroutines per connection.
+type Client struct {
+	hub *Hub
+
+	// id identifies this client across pods; it is assigned at connect
+	// time and used as the Envelope.Recipient clients address each other
+	// with.
+	id string
+
+	conn *websocket.Conn
+
+	// Buffered channel of outbound messages. Buffering means a slow
+	// reader can fall behind without blocking the hub's broadcast loop.
+	send chan []byte
+}
+
+// outgoing is the shape a client sends over the websocket: a recipient ID
+// (empty for broadcast) and the message content. The sender and origin pod
+// are filled in server-side before the message is published.
+type outgoing struct {
+	Recipient string `json:"recipient"`
+	Content   string `json:"content"`
+}
+
+// readPump pumps messages from the websocket connection, decodes each one
+// as JSON and hands the resulting Envelope to publish, which is responsible
+// for getting it onto Kafka. readPump owns unregistering the client and
+// closing the connection on exit.
+func (c *Client) readPump(publish func(*Envelope)) {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("error: %v", err)
+			}
+			break
+		}
+		msg = bytes.TrimSpace(bytes.Replace(msg, newline, space, -1))
+
+		var out outgoing
+		if err := json.Unmarshal(msg, &out); err != nil {
+			log.Printf("error: invalid message from %s: %v", c.id, err)
+			continue
+		}
+		publish(&Envelope{
+			Sender:    c.id,
+			Recipient: out.Recipient,
+			Content:   out.Content,
+			OriginPod: podName,
+		})
+	}
+}
+
+// writePump pumps messages from the hub to the websocket connection, and
+// pings the peer on an interval. writePump is the only goroutine that
+// writes to the connection.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel.
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
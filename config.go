@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/shopify/sarama"
+)
+
+// kafkaConfig holds the consumer-group tunables plus the connection security
+// settings, all overridable via environment variables so a deployment can
+// run against a secured cluster (MSK, Confluent Cloud, ...) without a code
+// change.
+type kafkaConfig struct {
+	Brokers []string
+	Topic   string
+
+	ConsumerGroup      string
+	ClientID           string
+	SessionTimeout     time.Duration
+	AutoCommitInterval time.Duration
+	ChannelBufferSize  int
+	Offset             int64
+
+	SASLEnabled   bool
+	SASLMechanism string // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	SASLUser      string
+	SASLPassword  string
+
+	SSLEnabled    bool
+	SSLSkipVerify bool
+	ClientCert    string
+	ClientKey     string
+	CACert        string
+}
+
+func loadKafkaConfig() kafkaConfig {
+	return kafkaConfig{
+		Brokers:            []string{envOr("kafka_brokers", "kafka")},
+		Topic:              topic,
+		ConsumerGroup:      envOr("kafka_consumer_group", "chat"),
+		ClientID:           envOr("kafka_client_id", podName),
+		SessionTimeout:     durationOr("kafka_session_timeout", 10*time.Second),
+		AutoCommitInterval: durationOr("kafka_autocommit_interval", time.Second),
+		ChannelBufferSize:  intOr("kafka_channel_buffer_size", 256),
+		Offset:             offsetOr("kafka_offset", sarama.OffsetNewest),
+
+		SASLEnabled:   boolOr("kafka_sasl_enabled", false),
+		SASLMechanism: envOr("kafka_sasl_mechanism", "PLAIN"),
+		SASLUser:      os.Getenv("kafka_sasl_user"),
+		SASLPassword:  os.Getenv("kafka_sasl_password"),
+
+		SSLEnabled:    boolOr("kafka_ssl_enabled", false),
+		SSLSkipVerify: boolOr("kafka_ssl_skip_verify", false),
+		ClientCert:    os.Getenv("kafka_ssl_client_cert"),
+		ClientKey:     os.Getenv("kafka_ssl_client_key"),
+		CACert:        os.Getenv("kafka_ssl_ca_cert"),
+	}
+}
+
+// toSarama builds the *sarama.Config shared by the producer and consumer
+// group from the tunables above, including SASL and TLS when enabled.
+func (c kafkaConfig) toSarama() (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = c.ClientID
+	cfg.Producer.Return.Successes = true
+	cfg.Consumer.Group.Session.Timeout = c.SessionTimeout
+	cfg.Consumer.Offsets.AutoCommit.Interval = c.AutoCommitInterval
+	cfg.Consumer.Offsets.Initial = c.Offset
+	cfg.ChannelBufferSize = c.ChannelBufferSize
+
+	if c.SASLEnabled {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = c.SASLUser
+		cfg.Net.SASL.Password = c.SASLPassword
+		switch c.SASLMechanism {
+		case "SCRAM-SHA-256":
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = scramClientGenerator(sha256HashGenerator)
+		case "SCRAM-SHA-512":
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = scramClientGenerator(sha512HashGenerator)
+		default:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		}
+	}
+
+	if c.SSLEnabled {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kafka TLS config: %w", err)
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+
+	return cfg, nil
+}
+
+// tlsConfig builds a *tls.Config from the PEM paths and skip-verify flag.
+// The client cert/key are optional; the CA cert is only needed when the
+// broker's certificate isn't already trusted by the system pool.
+func (c kafkaConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.SSLSkipVerify}
+
+	if c.ClientCert != "" && c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert/key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CACert != "" {
+		caCert, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("read CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func boolOr(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func durationOr(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func intOr(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func offsetOr(key string, fallback int64) int64 {
+	switch os.Getenv(key) {
+	case "oldest":
+		return sarama.OffsetOldest
+	case "newest":
+		return sarama.OffsetNewest
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+// Envelope is the message format exchanged on both sides of Kafka: clients
+// publish it over the websocket and every pod republishes whatever it reads
+// from Kafka back out to its local clients. OriginPod is carried along for
+// diagnostics; Recipient empty means broadcast to everyone.
+type Envelope struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Content   string `json:"content"`
+	OriginPod string `json:"origin_pod"`
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Hub maintains the registry of active clients, keyed by client ID, and the
+// channels used to register, unregister and broadcast to them. All access to
+// the registry goes through the hub's run loop, so it never needs its own
+// mutex even though clients connect and disconnect from their own
+// goroutines.
+type Hub struct {
+	// Registered clients, keyed by Client.id.
+	clients map[string]*Client
+
+	// Envelopes to be delivered, read from Kafka. An empty Recipient means
+	// broadcast to every locally registered client.
+	broadcast chan *Envelope
+
+	// Register requests from clients.
+	register chan *Client
+
+	// Unregister requests from clients.
+	unregister chan *Client
+}
+
+func newHub() *Hub {
+	return &Hub{
+		broadcast:  make(chan *Envelope),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		clients:    make(map[string]*Client),
+	}
+}
+
+// run drives the hub until ctx is cancelled. It must only be called once,
+// from its own goroutine; every field it touches is only ever read or
+// written here. On cancellation it closes every registered client with a
+// 1001 (going away) close frame, but it keeps servicing register/unregister
+// (and draining broadcast) until every client it closed has unregistered,
+// since each client's readPump unconditionally sends to h.unregister on its
+// way out; returning before that drains would leave those goroutines
+// blocked on a channel nobody reads from again.
+func (h *Hub) run(ctx context.Context) {
+	shuttingDown := false
+	pendingUnregisters := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if shuttingDown {
+				continue
+			}
+			shuttingDown = true
+			for id, client := range h.clients {
+				client.conn.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+					time.Now().Add(writeWait),
+				)
+				close(client.send)
+				delete(h.clients, id)
+				pendingUnregisters++
+			}
+			if pendingUnregisters == 0 {
+				return
+			}
+		case client := <-h.register:
+			if shuttingDown {
+				// Reject late registrations, but still wait for this
+				// client's readPump to unregister once writePump notices
+				// the closed send channel and tears down the connection.
+				close(client.send)
+				pendingUnregisters++
+				continue
+			}
+			h.clients[client.id] = client
+		case client := <-h.unregister:
+			if shuttingDown {
+				pendingUnregisters--
+				if pendingUnregisters == 0 {
+					return
+				}
+				continue
+			}
+			if _, ok := h.clients[client.id]; ok {
+				delete(h.clients, client.id)
+				close(client.send)
+			}
+		case envelope := <-h.broadcast:
+			if shuttingDown {
+				// No local clients left to deliver to; drain so senders
+				// like ConsumeClaim don't block on a full buffer.
+				continue
+			}
+			payload, err := json.Marshal(envelope)
+			if err != nil {
+				continue
+			}
+			for id, client := range h.clients {
+				if envelope.Recipient != "" && envelope.Recipient != id {
+					continue
+				}
+				select {
+				case client.send <- payload:
+				default:
+					// client's send buffer is full; drop it instead of
+					// letting it block delivery to everyone else.
+					close(client.send)
+					delete(h.clients, id)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gorilla/websocket"
+)
+
+// TestHubShutdownReleasesGoroutines drives a client through the hub and then
+// cancels the hub's context, verifying that readPump, writePump and run all
+// exit instead of leaking.
+func TestHubShutdownReleasesGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	hub := newHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		client := &Client{hub: hub, id: "test-client", conn: ws, send: make(chan []byte, 256)}
+		client.hub.register <- client
+
+		go client.writePump()
+		client.readPump(func(*Envelope) {})
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"recipient":"","content":"hi"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	cancel()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/shopify/sarama"
+)
+
+// groupHandler implements sarama.ConsumerGroupHandler, forwarding every
+// claimed message to the hub. Offsets are marked after a message has been
+// handed off for broadcast and committed on cfg.AutoCommitInterval. The hand
+// off selects on the session's context so a session ending while the hub is
+// mid-shutdown doesn't block ConsumeClaim on a channel the hub has stopped
+// reading from.
+type groupHandler struct {
+	hub *Hub
+}
+
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		var envelope Envelope
+		if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+			log.Printf("error: malformed envelope: %v", err)
+			sess.MarkMessage(msg, "")
+			continue
+		}
+		select {
+		case h.hub.broadcast <- &envelope:
+			sess.MarkMessage(msg, "")
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// consumeMessages joins the configured consumer group and feeds every
+// message it's claimed to the hub until ctx is cancelled. A multi-partition
+// topic lets several pod replicas share the group without duplicating
+// deliveries.
+func consumeMessages(ctx context.Context, hub *Hub, cfg kafkaConfig) {
+	saramaCfg, err := cfg.toSarama()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	group, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.ConsumerGroup, saramaCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer group.Close()
+
+	handler := &groupHandler{hub: hub}
+	for {
+		if err := group.Consume(ctx, []string{cfg.Topic}, handler); err != nil {
+			log.Printf("error: %v", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/shopify/sarama"
+)
+
+// fakeConsumerGroupClaim is a minimal sarama.ConsumerGroupClaim backed by a
+// channel the test controls directly, standing in for the real Kafka broker
+// connection consumeMessages would otherwise need.
+type fakeConsumerGroupClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeConsumerGroupClaim) Topic() string                            { return "chat" }
+func (c *fakeConsumerGroupClaim) Partition() int32                         { return 0 }
+func (c *fakeConsumerGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeConsumerGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// fakeConsumerGroupSession is a minimal sarama.ConsumerGroupSession whose
+// Context is cancelled the same way a real session's is when the consumer
+// group rebalances or shuts down.
+type fakeConsumerGroupSession struct {
+	ctx    context.Context
+	marked chan *sarama.ConsumerMessage
+}
+
+func (s *fakeConsumerGroupSession) Claims() map[string][]int32               { return nil }
+func (s *fakeConsumerGroupSession) MemberID() string                         { return "test-member" }
+func (s *fakeConsumerGroupSession) GenerationID() int32                      { return 0 }
+func (s *fakeConsumerGroupSession) MarkOffset(string, int32, int64, string)  {}
+func (s *fakeConsumerGroupSession) Commit()                                  {}
+func (s *fakeConsumerGroupSession) ResetOffset(string, int32, int64, string) {}
+func (s *fakeConsumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked <- msg
+}
+func (s *fakeConsumerGroupSession) Context() context.Context { return s.ctx }
+
+// TestGroupHandlerConsumeClaimDeliversToHub checks the happy path: a message
+// claimed from Kafka reaches a registered client through the hub.
+func TestGroupHandlerConsumeClaimDeliversToHub(t *testing.T) {
+	hub := newHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.run(ctx)
+
+	client := &Client{hub: hub, id: "test-client", send: make(chan []byte, 1)}
+	hub.register <- client
+
+	envelope, err := json.Marshal(&Envelope{Sender: "a", Content: "hi"})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	claim := &fakeConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Value: envelope}
+	close(claim.messages)
+
+	sess := &fakeConsumerGroupSession{ctx: context.Background(), marked: make(chan *sarama.ConsumerMessage, 1)}
+	handler := &groupHandler{hub: hub}
+	if err := handler.ConsumeClaim(sess, claim); err != nil {
+		t.Fatalf("ConsumeClaim: %v", err)
+	}
+
+	select {
+	case payload := <-client.send:
+		if string(payload) != string(envelope) {
+			t.Errorf("send = %s, want %s", payload, envelope)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for broadcast")
+	}
+
+	// Unregister before cancelling so the hub's shutdown path, which
+	// writes a close frame to every still-registered client, doesn't
+	// touch this client's nil conn.
+	hub.unregister <- client
+}
+
+// TestGroupHandlerConsumeClaimExitsOnSessionDone verifies ConsumeClaim
+// doesn't leak a goroutine blocked on h.hub.broadcast when the session ends
+// while the hub is mid-shutdown and no longer reading from it, mirroring
+// TestHubShutdownReleasesGoroutines for the hub side of the same race.
+func TestGroupHandlerConsumeClaimExitsOnSessionDone(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	hub := newHub()
+	hubCtx, hubCancel := context.WithCancel(context.Background())
+	hubDone := make(chan struct{})
+	go func() {
+		hub.run(hubCtx)
+		close(hubDone)
+	}()
+	hubCancel()
+	<-hubDone // hub.run has returned; nothing reads hub.broadcast anymore.
+
+	sessCtx, sessCancel := context.WithCancel(context.Background())
+	claim := &fakeConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Value: []byte(`{"content":"hi"}`)}
+
+	sess := &fakeConsumerGroupSession{ctx: sessCtx, marked: make(chan *sarama.ConsumerMessage, 1)}
+	handler := &groupHandler{hub: hub}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.ConsumeClaim(sess, claim)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	sessCancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ConsumeClaim: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeClaim did not return after session context was cancelled")
+	}
+}
@@ -1,48 +1,100 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/shopify/sarama"
-	"github.com/gorilla/websocket"
 )
 
-// map used for keeping a list of clients where broadcasts need to be sent
-var clients = make(map[*websocket.Conn]bool)
-
-// channel used to pass messages that need to be broadcast
-var broadcast = make(chan []byte)
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-var podName string
-
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
 )
 
+var podName string
+
+// topic is the Kafka topic every pod publishes to and consumes from, so a
+// message sent on one pod can reach a client connected to another.
+var topic string
+
 func init() {
 	podName = os.Getenv("podname")
 	if podName == "" {
 		podName = "Unknown Pod Name"
 	}
+
+	topic = os.Getenv("topic")
+	if topic == "" {
+		topic = "chat"
+	}
 }
 
 func main() {
-	http.HandleFunc("/", serveHome)
-	http.HandleFunc("/ws", handleWSConnections)
-	go handleMessages()
-	err := http.ListenAndServe(":8000", nil)
+	cfg := loadKafkaConfig()
+
+	saramaCfg, err := cfg.toSarama()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A single producer is shared by every connection instead of creating
+	// one per WebSocket upgrade.
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaCfg)
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatal(err)
+	}
+	defer producer.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	hub := newHub()
+	go hub.run(ctx)
+
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		consumeMessages(ctx, hub, cfg)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveHome)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWs(hub, producer, w, r)
+	})
+	mux.HandleFunc("/events", serveEvents(cfg))
+	server := &http.Server{Addr: ":8000", Handler: mux}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	case <-ctx.Done():
+		log.Println("shutting down")
 	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error: %v", err)
+	}
+
+	<-consumerDone
 }
 
 // Handler for the root path. It merely returns the formatted content of the
@@ -55,72 +107,33 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	homePage.Execute(w, podstr)
 }
 
-// Handler for the websockets connections
-func handleWSConnections(w http.ResponseWriter, r *http.Request) {
-
-	// upgrade the connection to a websocket
+// serveWs upgrades the HTTP connection to a websocket, registers a Client
+// with the hub and starts its read/write pumps. Everything read from the
+// client is published to the shared Kafka topic via producer as an
+// Envelope, addressed to whatever recipient the client chose.
+func serveWs(hub *Hub, producer sarama.SyncProducer, w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer ws.Close()
-
-	//create an instance of a SyncProducer
-	producer, err := sarama.NewSyncProducer([]string{"kafka"}, nil)
-	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return
 	}
-	defer producer.Close()
+	ws.EnableWriteCompression(true)
 
-	// keep track of the clients in order to be able to send broadcasts
-	clients[ws] = true
+	client := &Client{hub: hub, id: uuid.NewString(), conn: ws, send: make(chan []byte, 256)}
+	client.hub.register <- client
 
-	// endless loop that reads from the websocket and writes them to the broadcast channel
-	for {
-		_, text, err := ws.ReadMessage()
+	go client.writePump()
+	go client.readPump(func(envelope *Envelope) {
+		payload, err := json.Marshal(envelope)
 		if err != nil {
 			log.Printf("error: %v", err)
-			delete(clients, ws)
-			break
-		}
-		text = bytes.TrimSpace(bytes.Replace(msg, newline, space, -1))
-
-		msg := &sarama.ProducerMessage{Topic: podName, Value: sarama.StringEncoder(text)}
-		_, _, err := producer.SendMessage(msg)
-		if err != nil {
-			log.Fatal(err)
+			return
 		}
-		//broadcast <- msg
-	}
-}
-
-// Handler that listens to messages coming from the broadcast channel and
-// sends them to each one of the websocket clients.
-// We should probably implement some sanitation here to prevent injecting malicious code to clients.
-func handleMessages() {
-	consumer, err := sarama.NewConsumer([]string{"kafka"}, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer consumer.Close()
-  
-	partitionConsumer, err := consumer.ConsumePartition(podName, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer partitionConsumer.Close()
-
-	for {
-		msg := <-partitionConsumer.Messages()
-		for client := range clients {
-			err := client.WriteMessage(websocket.TextMessage, msg.Value)
-			if err != nil {
-				log.Printf("error: %v", err)
-				client.Close()
-				delete(clients, client)
-			}
+		msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(payload)}
+		if _, _, err := producer.SendMessage(msg); err != nil {
+			log.Printf("error: %v", err)
 		}
-	}
+	})
 }
 
 // Template to render a UI. Then only variable is the Pod Name.
@@ -152,7 +165,7 @@ window.onload = function () {
         if (!msg.value) {
             return false;
         }
-        conn.send(msg.value);
+        conn.send(JSON.stringify({recipient: "", content: msg.value}));
         msg.value = "";
         return false;
     };
@@ -165,12 +178,10 @@ window.onload = function () {
             appendLog(item);
         };
         conn.onmessage = function (evt) {
-            var messages = evt.data.split('\n');
-            for (var i = 0; i < messages.length; i++) {
-                var item = document.createElement("div");
-                item.innerText = messages[i];
-                appendLog(item);
-            }
+            var envelope = JSON.parse(evt.data);
+            var item = document.createElement("div");
+            item.innerText = envelope.sender + " (" + envelope.origin_pod + "): " + envelope.content;
+            appendLog(item);
         };
     } else {
         var item = document.createElement("div");
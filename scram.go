@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// sha256HashGenerator and sha512HashGenerator satisfy scram.HashGeneratorFcn
+// for the two SCRAM mechanisms sarama supports alongside PLAIN.
+var (
+	sha256HashGenerator scram.HashGeneratorFcn = func() hash.Hash { return sha256.New() }
+	sha512HashGenerator scram.HashGeneratorFcn = func() hash.Hash { return sha512.New() }
+)
+
+// scramClient adapts github.com/xdg-go/scram to sarama.SCRAMClient.
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
+
+// scramClientGenerator returns a sarama.SCRAMClientGeneratorFunc bound to
+// the given hash algorithm.
+func scramClientGenerator(hashGenerator scram.HashGeneratorFcn) func() sarama.SCRAMClient {
+	return func() sarama.SCRAMClient {
+		return &scramClient{HashGeneratorFcn: hashGenerator}
+	}
+}
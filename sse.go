@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopify/sarama"
+)
+
+// sseHeartbeatInterval is how often serveEvents writes a comment-only event
+// to keep the connection alive through proxies that time out idle streams.
+const sseHeartbeatInterval = 15 * time.Second
+
+// sseMessage pairs a Kafka message with the partition it was read from, so
+// the SSE event ID can encode enough information for a reconnecting client
+// to resume that specific partition.
+type sseMessage struct {
+	partition int32
+	message   *sarama.ConsumerMessage
+}
+
+// serveEvents streams the shared topic as Server-Sent Events for clients
+// that can't, or won't, use WebSockets. Like Hub.run, it drops envelopes
+// addressed to a specific recipient so /events never leaks private
+// messages to an unauthenticated stream. It consumes every partition of
+// the topic, the same coverage the Hub's consumer group gets, and fans
+// them into one stream. Each event's ID is "partition:offset", so a
+// reconnecting client's Last-Event-ID resumes only the partition it came
+// from instead of replaying everything or missing messages in between.
+func serveEvents(cfg kafkaConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		resumeOffsets := map[int32]int64{}
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			if partition, offset, ok := parseEventID(id); ok {
+				resumeOffsets[partition] = offset + 1
+			}
+		}
+
+		saramaCfg, err := cfg.toSarama()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		consumer, err := sarama.NewConsumer(cfg.Brokers, saramaCfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer consumer.Close()
+
+		partitions, err := consumer.Partitions(cfg.Topic)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		messages := make(chan sseMessage)
+		errs := make(chan error, len(partitions))
+		done := make(chan struct{})
+		defer close(done)
+
+		for _, partition := range partitions {
+			offset, ok := resumeOffsets[partition]
+			if !ok {
+				offset = sarama.OffsetNewest
+			}
+			partitionConsumer, err := consumer.ConsumePartition(cfg.Topic, partition, offset)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer partitionConsumer.Close()
+
+			go pumpPartition(partition, partitionConsumer, messages, errs, done)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		ctx := r.Context()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sm := <-messages:
+				var envelope Envelope
+				if err := json.Unmarshal(sm.message.Value, &envelope); err != nil {
+					log.Printf("error: malformed envelope: %v", err)
+					continue
+				}
+				if envelope.Recipient != "" {
+					// /events is unauthenticated; only broadcasts are
+					// safe to stream to it.
+					continue
+				}
+				fmt.Fprintf(w, "id: %d:%d\nevent: message\ndata: %s\n\n", sm.partition, sm.message.Offset, sm.message.Value)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case err := <-errs:
+				log.Printf("error: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// pumpPartition forwards messages and errors from a single partition
+// consumer into the shared channels until done is closed.
+func pumpPartition(partition int32, pc sarama.PartitionConsumer, messages chan<- sseMessage, errs chan<- error, done <-chan struct{}) {
+	for {
+		select {
+		case msg := <-pc.Messages():
+			select {
+			case messages <- sseMessage{partition: partition, message: msg}:
+			case <-done:
+				return
+			}
+		case err := <-pc.Errors():
+			select {
+			case errs <- err:
+			case <-done:
+			}
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// parseEventID splits a "partition:offset" Last-Event-ID value produced by
+// serveEvents back into its parts.
+func parseEventID(id string) (partition int32, offset int64, ok bool) {
+	idx := strings.IndexByte(id, ':')
+	if idx < 0 {
+		return 0, 0, false
+	}
+	p, err := strconv.ParseInt(id[:idx], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	o, err := strconv.ParseInt(id[idx+1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int32(p), o, true
+}